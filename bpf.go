@@ -0,0 +1,638 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/bpf"
+)
+
+// BPF lowers the header of a Rule (protocol, nets and ports, and the
+// Bidirectional flag) into a cheap pre-filter: a tcpdump-style expression
+// for humans, and an assembled BPF program that can be attached to an
+// AF_PACKET socket to drop packets that cannot possibly match the rule
+// before the expensive content/PCRE matching runs.
+//
+// vars resolves rule variables such as $HOME_NET or $HTTP_PORTS to their
+// concrete CIDRs/ports. A variable that is not present in vars, or a field
+// set to "any", is treated as "match all" for that field rather than as an
+// error.
+//
+// The assembled program assumes Ethernet II framing and an IPv4 header
+// without options; it matches the protocol's source/destination IP and,
+// for tcp/udp, source/destination port. IPv6 nets (negated or not) and
+// negated port ranges are reflected in the returned expression string but
+// cannot be lowered into the instruction stream. A side whose nets are all
+// IPv6, or a negated port range, is reported as an error; IPv6 nets in a
+// list that also holds IPv4 nets are left out of the program without an
+// error.
+func (r *Rule) BPF(vars map[string][]string) (string, []bpf.RawInstruction, error) {
+	src := resolveSide(r.Source, vars)
+	dst := resolveSide(r.Destination, vars)
+
+	prog, err := r.buildProgram(src, dst, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("sid %d: %v", r.SID, err)
+	}
+	prog = append(prog, bpf.RetConstant{Val: 0})
+
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return "", nil, fmt.Errorf("sid %d: assembling bpf program: %v", r.SID, err)
+	}
+	return r.bpfExpr(src, dst), raw, nil
+}
+
+// Ruleset is a collection of Rules that can be compiled into a single
+// combined BPF pre-filter.
+type Ruleset []*Rule
+
+// BPF compiles the header of every Rule in rs into one pre-filter that
+// accepts a packet if it could match any of them, deduplicating rules whose
+// resolved header (protocol, nets, ports and direction) is equivalent. See
+// Rule.BPF for the semantics of vars and the limitations of the assembled
+// program.
+func (rs Ruleset) BPF(vars map[string][]string) (string, []bpf.RawInstruction, error) {
+	var rules []*Rule
+	var sides [][2]bpfSide
+	var exprs []string
+	seen := map[string]bool{}
+
+	for _, r := range rs {
+		src := resolveSide(r.Source, vars)
+		dst := resolveSide(r.Destination, vars)
+		expr := r.bpfExpr(src, dst)
+
+		key := fmt.Sprintf("%s\x00%v\x00%s", r.Protocol, r.Bidirectional, expr)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		rules = append(rules, r)
+		sides = append(sides, [2]bpfSide{src, dst})
+		if expr != "" {
+			exprs = append(exprs, "("+expr+")")
+		}
+	}
+
+	prog, err := chainPrograms(rules, sides)
+	if err != nil {
+		return "", nil, err
+	}
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return "", nil, fmt.Errorf("assembling ruleset bpf program: %v", err)
+	}
+	return strings.Join(exprs, " or "), raw, nil
+}
+
+// chainPrograms assembles one rule per entry in rules/sides into a single
+// program that accepts if any rule's own chain accepts, by having each
+// rule's failure fall through into the next rule's chain; the final rule's
+// failure rejects the packet.
+func chainPrograms(rules []*Rule, sides [][2]bpfSide) ([]bpf.Instruction, error) {
+	chains := make([][]bpf.Instruction, len(rules))
+	for i := len(rules) - 1; i >= 0; i-- {
+		instrs, err := rules[i].buildProgram(sides[i][0], sides[i][1], 0)
+		if err != nil {
+			return nil, fmt.Errorf("sid %d: %v", rules[i].SID, err)
+		}
+		chains[i] = instrs
+	}
+	var out []bpf.Instruction
+	for _, c := range chains {
+		out = append(out, c...)
+	}
+	return append(out, bpf.RetConstant{Val: 0}), nil
+}
+
+// Offsets assume Ethernet II framing and an IPv4 header with no options.
+const (
+	snapLen = 65535
+
+	etherTypeOffset = 12
+	etherTypeIPv4   = 0x0800
+
+	ipProtoOffset = 23
+	ipSrcOffset   = 26
+	ipDstOffset   = 30
+
+	l4SrcPortOffset = 34
+	l4DstPortOffset = 36
+)
+
+var protoNumbers = map[string]uint32{
+	"tcp":  6,
+	"udp":  17,
+	"icmp": 1,
+}
+
+// portRange is an inclusive range of ports; lo == hi for a single port.
+type portRange struct {
+	lo, hi int
+}
+
+// bpfSide is the resolved form of a Network: concrete CIDRs and port
+// ranges, plus whether each list is negated or unconstrained ("any" or an
+// unresolved variable).
+type bpfSide struct {
+	nets    []*net.IPNet
+	netsAll bool
+	netsNeg bool
+
+	ports    []portRange
+	portsAll bool
+	portsNeg bool
+}
+
+// splitNegatable splits a single rule header field (e.g. "![80,443]",
+// "$HTTP_PORTS", "1024:") into its element list and whether the whole list
+// is negated.
+func splitNegatable(field string) ([]string, bool) {
+	f := field
+	negate := false
+	if strings.HasPrefix(f, "!") {
+		negate = true
+		f = f[1:]
+	}
+	if strings.HasPrefix(f, "[") && strings.HasSuffix(f, "]") {
+		f = f[1 : len(f)-1]
+	}
+	var elems []string
+	for _, e := range strings.Split(f, ",") {
+		elems = append(elems, strings.TrimSpace(e))
+	}
+	return elems, negate
+}
+
+// flattenField expands every element of a Network.Nets/Ports list (each of
+// which may itself still be an unsplit bracketed/negated field, depending
+// on how the rule was parsed) into a flat element list, and reports whether
+// any element negated the list.
+func flattenField(field []string) ([]string, bool) {
+	var elems []string
+	negate := false
+	for _, f := range field {
+		e, n := splitNegatable(f)
+		elems = append(elems, e...)
+		negate = negate || n
+	}
+	return elems, negate
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func maskToUint32(mask net.IPMask) uint32 {
+	return binary.BigEndian.Uint32(mask)
+}
+
+// resolveCIDRs resolves a flattened net element list to concrete CIDRs. A
+// bare "any", an empty element, or a variable absent from vars yields
+// matchAll.
+func resolveCIDRs(elems []string, vars map[string][]string) (nets []*net.IPNet, matchAll bool) {
+	for _, e := range elems {
+		if e == "" || e == "any" {
+			return nil, true
+		}
+		vals := []string{e}
+		if strings.HasPrefix(e, "$") {
+			v, ok := vars[e]
+			if !ok {
+				return nil, true
+			}
+			vals = v
+		}
+		for _, v := range vals {
+			_, ipnet, err := net.ParseCIDR(v)
+			if err != nil {
+				ip := net.ParseIP(v)
+				if ip == nil {
+					return nil, true
+				}
+				bits := 32
+				if ip.To4() == nil {
+					bits = 128
+				}
+				ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			}
+			nets = append(nets, ipnet)
+		}
+	}
+	return nets, false
+}
+
+// parsePortRange parses a single port field element: "80", "1024:",
+// ":1024" or "1024:2048".
+func parsePortRange(s string) (portRange, error) {
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		lo, hi := 0, 65535
+		var err error
+		if loS := s[:i]; loS != "" {
+			if lo, err = strconv.Atoi(loS); err != nil {
+				return portRange{}, err
+			}
+		}
+		if hiS := s[i+1:]; hiS != "" {
+			if hi, err = strconv.Atoi(hiS); err != nil {
+				return portRange{}, err
+			}
+		}
+		return portRange{lo, hi}, nil
+	}
+	p, err := strconv.Atoi(s)
+	if err != nil {
+		return portRange{}, err
+	}
+	return portRange{p, p}, nil
+}
+
+// resolvePorts resolves a flattened port element list to concrete port
+// ranges. A bare "any", an empty element, or a variable absent from vars
+// yields matchAll.
+func resolvePorts(elems []string, vars map[string][]string) (ranges []portRange, matchAll bool) {
+	for _, e := range elems {
+		if e == "" || e == "any" {
+			return nil, true
+		}
+		vals := []string{e}
+		if strings.HasPrefix(e, "$") {
+			v, ok := vars[e]
+			if !ok {
+				return nil, true
+			}
+			vals = v
+		}
+		for _, v := range vals {
+			pr, err := parsePortRange(v)
+			if err != nil {
+				return nil, true
+			}
+			ranges = append(ranges, pr)
+		}
+	}
+	return ranges, false
+}
+
+func resolveSide(n Network, vars map[string][]string) bpfSide {
+	netElems, netNeg := flattenField(n.Nets)
+	nets, netAll := resolveCIDRs(netElems, vars)
+	portElems, portNeg := flattenField(n.Ports)
+	ports, portAll := resolvePorts(portElems, vars)
+	return bpfSide{
+		nets:     nets,
+		netsAll:  netAll,
+		netsNeg:  netNeg,
+		ports:    ports,
+		portsAll: portAll,
+		portsNeg: portNeg,
+	}
+}
+
+func netsExpr(dir string, s bpfSide) string {
+	if s.netsAll {
+		return ""
+	}
+	parts := make([]string, 0, len(s.nets))
+	for _, n := range s.nets {
+		parts = append(parts, fmt.Sprintf("%s net %s", dir, n.String()))
+	}
+	expr := strings.Join(parts, " or ")
+	if len(parts) > 1 {
+		expr = "(" + expr + ")"
+	}
+	if s.netsNeg {
+		expr = "not " + expr
+	}
+	return expr
+}
+
+func portsExpr(dir string, s bpfSide) string {
+	if s.portsAll {
+		return ""
+	}
+	parts := make([]string, 0, len(s.ports))
+	for _, r := range s.ports {
+		if r.lo == r.hi {
+			parts = append(parts, fmt.Sprintf("%s port %d", dir, r.lo))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s portrange %d-%d", dir, r.lo, r.hi))
+		}
+	}
+	expr := strings.Join(parts, " or ")
+	if len(parts) > 1 {
+		expr = "(" + expr + ")"
+	}
+	if s.portsNeg {
+		expr = "not " + expr
+	}
+	return expr
+}
+
+func sideExpr(dir string, s bpfSide) string {
+	var parts []string
+	if e := netsExpr(dir, s); e != "" {
+		parts = append(parts, e)
+	}
+	if e := portsExpr(dir, s); e != "" {
+		parts = append(parts, e)
+	}
+	return strings.Join(parts, " and ")
+}
+
+// directionalExpr builds the tcpdump-style expression for one direction
+// (src -> dst) of a rule's header.
+func directionalExpr(proto string, src, dst bpfSide) string {
+	var parts []string
+	if _, ok := protoNumbers[strings.ToLower(proto)]; ok {
+		parts = append(parts, strings.ToLower(proto))
+	}
+	if e := sideExpr("src", src); e != "" {
+		parts = append(parts, e)
+	}
+	if e := sideExpr("dst", dst); e != "" {
+		parts = append(parts, e)
+	}
+	return strings.Join(parts, " and ")
+}
+
+// bpfExpr builds the tcpdump-style expression for the whole rule, ORing
+// the two directions together for a Bidirectional rule.
+func (r *Rule) bpfExpr(src, dst bpfSide) string {
+	fwd := directionalExpr(r.Protocol, src, dst)
+	if !r.Bidirectional {
+		return fwd
+	}
+	rev := directionalExpr(r.Protocol, dst, src)
+	switch {
+	case fwd == "" || rev == "":
+		return ""
+	case fwd == rev:
+		return fwd
+	default:
+		return fmt.Sprintf("(%s) or (%s)", fwd, rev)
+	}
+}
+
+// instrStep is one load(+mask)+compare BPF instruction pair.
+type instrStep struct {
+	off  uint32
+	size int
+	mask uint32
+	cond bpf.JumpTest
+	val  uint32
+}
+
+func (s instrStep) len() int {
+	if s.mask != 0 {
+		return 3
+	}
+	return 2
+}
+
+func (s instrStep) instrs(skipTrue, skipFalse uint8) []bpf.Instruction {
+	out := []bpf.Instruction{bpf.LoadAbsolute{Off: s.off, Size: s.size}}
+	if s.mask != 0 {
+		out = append(out, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: s.mask})
+	}
+	return append(out, bpf.JumpIf{Cond: s.cond, Val: s.val, SkipTrue: skipTrue, SkipFalse: skipFalse})
+}
+
+// alt is one alternative within an OR group: its steps must all pass (AND)
+// for the alternative to be considered true (used for port ranges, which
+// need a >= and a <= test).
+type alt struct {
+	steps []instrStep
+}
+
+func (a alt) len() int {
+	n := 0
+	for _, s := range a.steps {
+		n += s.len()
+	}
+	return n
+}
+
+func protoAlts(proto string) []alt {
+	n, ok := protoNumbers[strings.ToLower(proto)]
+	if !ok {
+		return nil
+	}
+	return []alt{{steps: []instrStep{{off: ipProtoOffset, size: 1, cond: bpf.JumpEqual, val: n}}}}
+}
+
+func netAlts(offset uint32, nets []*net.IPNet) []alt {
+	var alts []alt
+	for _, n := range nets {
+		v4 := n.IP.To4()
+		if v4 == nil {
+			// IPv6 is reflected only in the expression string; see BPF doc comment.
+			continue
+		}
+		alts = append(alts, alt{steps: []instrStep{{
+			off:  offset,
+			size: 4,
+			mask: maskToUint32(n.Mask),
+			cond: bpf.JumpEqual,
+			val:  ipToUint32(v4.Mask(n.Mask)),
+		}}})
+	}
+	return alts
+}
+
+func portAlts(offset uint32, ranges []portRange) []alt {
+	alts := make([]alt, 0, len(ranges))
+	for _, r := range ranges {
+		if r.lo == r.hi {
+			alts = append(alts, alt{steps: []instrStep{{off: offset, size: 2, cond: bpf.JumpEqual, val: uint32(r.lo)}}})
+			continue
+		}
+		alts = append(alts, alt{steps: []instrStep{
+			{off: offset, size: 2, cond: bpf.JumpGreaterOrEqual, val: uint32(r.lo)},
+			{off: offset, size: 2, cond: bpf.JumpLessOrEqual, val: uint32(r.hi)},
+		}})
+	}
+	return alts
+}
+
+// buildPositiveGroup builds an OR of alts: any alt matching jumps over the
+// rest of the group to the next group; the last alt failing jumps failSkip
+// instructions to the rule's reject path.
+func buildPositiveGroup(alts []alt, failSkip int) ([]bpf.Instruction, error) {
+	var out []bpf.Instruction
+	for i, a := range alts {
+		trailing := 0
+		for _, later := range alts[i+1:] {
+			trailing += later.len()
+		}
+		for j, step := range a.steps {
+			var skipTrue, skipFalse int
+			switch {
+			case j < len(a.steps)-1:
+				remaining := 0
+				for _, s := range a.steps[j+1:] {
+					remaining += s.len()
+				}
+				// A non-final step's failure must reach the same
+				// destination as the alt's own last-step failure: the
+				// next alt, or failSkip if this is the last alt.
+				if i == len(alts)-1 {
+					remaining += failSkip
+				}
+				skipTrue, skipFalse = 0, remaining
+			case i < len(alts)-1:
+				skipTrue, skipFalse = trailing, 0
+			default:
+				skipTrue, skipFalse = 0, failSkip
+			}
+			if skipTrue > 255 || skipFalse > 255 {
+				return nil, fmt.Errorf("bpf program too large to encode relative jumps")
+			}
+			out = append(out, step.instrs(uint8(skipTrue), uint8(skipFalse))...)
+		}
+	}
+	return out, nil
+}
+
+// buildNegatedGroup builds an AND of "not alt" for every alt: any alt
+// matching rejects immediately, since the field was negated.
+func buildNegatedGroup(alts []alt, failSkip int) ([]bpf.Instruction, error) {
+	var out []bpf.Instruction
+	for i, a := range alts {
+		if len(a.steps) != 1 {
+			return nil, fmt.Errorf("negated port ranges cannot be lowered into a bpf program")
+		}
+		// failSkip counts from the group's last instruction, so an earlier
+		// alt's match must also skip the alts that follow it.
+		skip := failSkip
+		for _, later := range alts[i+1:] {
+			skip += later.len()
+		}
+		if skip > 255 {
+			return nil, fmt.Errorf("bpf program too large to encode relative jumps")
+		}
+		out = append(out, a.steps[0].instrs(uint8(skip), 0)...)
+	}
+	return out, nil
+}
+
+func buildGroup(alts []alt, negate bool, failSkip int) ([]bpf.Instruction, error) {
+	if negate {
+		return buildNegatedGroup(alts, failSkip)
+	}
+	return buildPositiveGroup(alts, failSkip)
+}
+
+// buildDirectionalInstrs builds the AND-of-groups instruction chain for one
+// direction (src -> dst) of a rule's header. trailing is the number of
+// instructions past this chain's own accept that a failed check skips: with
+// 0, a failure lands on the instruction right after the accept, which is the
+// next alternative when the chain is one leg of an OR (a Bidirectional
+// rule's forward direction, or a rule in a Ruleset) and the final reject
+// otherwise. The returned
+// instructions end with this chain's own accept; the caller is responsible
+// for the final reject.
+func buildDirectionalInstrs(proto string, src, dst bpfSide, trailing int) ([]bpf.Instruction, error) {
+	type group struct {
+		alts   []alt
+		negate bool
+	}
+	groups := []group{
+		{alts: []alt{{steps: []instrStep{{off: etherTypeOffset, size: 2, cond: bpf.JumpEqual, val: etherTypeIPv4}}}}},
+	}
+	if a := protoAlts(proto); len(a) > 0 {
+		groups = append(groups, group{alts: a})
+	}
+	if !src.netsAll {
+		a := netAlts(ipSrcOffset, src.nets)
+		if len(a) == 0 && len(src.nets) > 0 {
+			return nil, fmt.Errorf("IPv6-only nets cannot be lowered into a bpf program")
+		}
+		if len(a) > 0 {
+			groups = append(groups, group{alts: a, negate: src.netsNeg})
+		}
+	}
+	if !src.portsAll {
+		if a := portAlts(l4SrcPortOffset, src.ports); len(a) > 0 {
+			groups = append(groups, group{alts: a, negate: src.portsNeg})
+		}
+	}
+	if !dst.netsAll {
+		a := netAlts(ipDstOffset, dst.nets)
+		if len(a) == 0 && len(dst.nets) > 0 {
+			return nil, fmt.Errorf("IPv6-only nets cannot be lowered into a bpf program")
+		}
+		if len(a) > 0 {
+			groups = append(groups, group{alts: a, negate: dst.netsNeg})
+		}
+	}
+	if !dst.portsAll {
+		if a := portAlts(l4DstPortOffset, dst.ports); len(a) > 0 {
+			groups = append(groups, group{alts: a, negate: dst.portsNeg})
+		}
+	}
+
+	sizes := make([]int, len(groups))
+	for i, g := range groups {
+		for _, a := range g.alts {
+			sizes[i] += a.len()
+		}
+	}
+
+	var out []bpf.Instruction
+	for i, g := range groups {
+		failSkip := 1 + trailing
+		for _, s := range sizes[i+1:] {
+			failSkip += s
+		}
+		instrs, err := buildGroup(g.alts, g.negate, failSkip)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, instrs...)
+	}
+	return append(out, bpf.RetConstant{Val: snapLen}), nil
+}
+
+// buildProgram builds the instruction chain for a rule, ORing its two
+// directions together when it is Bidirectional. See buildDirectionalInstrs
+// for the meaning of trailing.
+func (r *Rule) buildProgram(src, dst bpfSide, trailing int) ([]bpf.Instruction, error) {
+	if !r.Bidirectional {
+		return buildDirectionalInstrs(r.Protocol, src, dst, trailing)
+	}
+	rev, err := buildDirectionalInstrs(r.Protocol, dst, src, trailing)
+	if err != nil {
+		return nil, err
+	}
+	// fwd's own failures must fall through into rev, not jump past it: a
+	// failed fwd chain is only 0 instructions from the start of rev, which
+	// immediately follows it. rev carries the caller's trailing, since a
+	// failed rev chain is what must reach whatever follows this rule's
+	// program (the next rule's chain, or the final reject).
+	fwd, err := buildDirectionalInstrs(r.Protocol, src, dst, 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(fwd, rev...), nil
+}
@@ -0,0 +1,205 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/bpf"
+)
+
+var bpfVars = map[string][]string{
+	"$HOME_NET":   {"10.0.0.0/8"},
+	"$HTTP_PORTS": {"80", "8080"},
+}
+
+func TestRuleBPFExpr(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		rule string
+		want string
+	}{
+		{
+			name: "simple",
+			rule: `alert tcp $HOME_NET any -> any $HTTP_PORTS (sid:1; msg:"foo"; content:"A";)`,
+			want: `tcp and src net 10.0.0.0/8 and (dst port 80 or dst port 8080)`,
+		},
+		{
+			name: "unresolved variable matches all",
+			rule: `alert tcp $HOME_NET any -> $UNKNOWN_NET any (sid:1; msg:"foo"; content:"A";)`,
+			want: `tcp and src net 10.0.0.0/8`,
+		},
+		{
+			name: "bidirectional",
+			rule: `alert udp $HOME_NET any <> any 53 (sid:1; msg:"foo"; content:"A";)`,
+			want: `(udp and src net 10.0.0.0/8 and dst port 53) or (udp and src port 53 and dst net 10.0.0.0/8)`,
+		},
+	} {
+		r, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: ParseRule: %v", tt.name, err)
+		}
+		got, raw, err := r.BPF(bpfVars)
+		if err != nil {
+			t.Fatalf("%s: BPF: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got expr %q; want %q", tt.name, got, tt.want)
+		}
+		if len(raw) == 0 {
+			t.Errorf("%s: got empty assembled program", tt.name)
+		}
+	}
+}
+
+// ipv4Packet builds a minimal Ethernet II + IPv4 + L4-port packet at the
+// offsets bpf.go's instructions read, for feeding through a real bpf.VM.
+func ipv4Packet(proto byte, srcIP, dstIP net.IP, srcPort, dstPort uint16) []byte {
+	pkt := make([]byte, 38)
+	binary.BigEndian.PutUint16(pkt[etherTypeOffset:], etherTypeIPv4)
+	pkt[14] = 0x45 // version 4, no IP options
+	pkt[ipProtoOffset] = proto
+	copy(pkt[ipSrcOffset:ipSrcOffset+4], srcIP.To4())
+	copy(pkt[ipDstOffset:ipDstOffset+4], dstIP.To4())
+	binary.BigEndian.PutUint16(pkt[l4SrcPortOffset:], srcPort)
+	binary.BigEndian.PutUint16(pkt[l4DstPortOffset:], dstPort)
+	return pkt
+}
+
+// TestRuleBPFPortRangeVM runs the assembled program for a port *range*
+// through a real bpf.VM, on both sides of the range boundary. A plain
+// len(raw) == 0 check can't catch wrong jump offsets; actually executing
+// the program can.
+func TestRuleBPFPortRangeVM(t *testing.T) {
+	r, err := ParseRule(`alert tcp $HOME_NET 1024: -> any any (sid:1; msg:"foo"; content:"A";)`)
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	_, raw, err := r.BPF(bpfVars)
+	if err != nil {
+		t.Fatalf("BPF: %v", err)
+	}
+	insts, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatalf("Disassemble: could not decode all instructions")
+	}
+	vm, err := bpf.NewVM(insts)
+	if err != nil {
+		t.Fatalf("NewVM: %v", err)
+	}
+
+	srcIP, dstIP := net.ParseIP("10.1.2.3"), net.ParseIP("8.8.8.8")
+	for _, tt := range []struct {
+		name    string
+		srcPort uint16
+		want    bool
+	}{
+		{name: "in range", srcPort: 2048, want: true},
+		{name: "below range", srcPort: 80, want: false},
+	} {
+		pkt := ipv4Packet(byte(protoNumbers["tcp"]), srcIP, dstIP, tt.srcPort, 443)
+		n, err := vm.Run(pkt)
+		if err != nil {
+			t.Fatalf("%s: vm.Run: %v", tt.name, err)
+		}
+		if got := n > 0; got != tt.want {
+			t.Errorf("%s: src port %d: vm.Run returned %d (accepted=%v); want accepted=%v", tt.name, tt.srcPort, n, got, tt.want)
+		}
+	}
+}
+
+// TestRuleBPFBidirectionalVM runs a bidirectional rule's assembled program
+// through a real bpf.VM and checks that traffic matching only the reverse
+// leg is accepted, not just traffic matching the forward leg.
+func TestRuleBPFBidirectionalVM(t *testing.T) {
+	r, err := ParseRule(`alert tcp 10.0.0.0/8 1000 <> 20.0.0.0/8 2000 (sid:1; msg:"foo"; content:"A";)`)
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	_, raw, err := r.BPF(bpfVars)
+	if err != nil {
+		t.Fatalf("BPF: %v", err)
+	}
+	insts, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatalf("Disassemble: could not decode all instructions")
+	}
+	vm, err := bpf.NewVM(insts)
+	if err != nil {
+		t.Fatalf("NewVM: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name             string
+		srcIP, dstIP     net.IP
+		srcPort, dstPort uint16
+	}{
+		{name: "forward leg", srcIP: net.ParseIP("10.1.2.3"), dstIP: net.ParseIP("20.1.2.3"), srcPort: 1000, dstPort: 2000},
+		{name: "reverse leg", srcIP: net.ParseIP("20.1.2.3"), dstIP: net.ParseIP("10.1.2.3"), srcPort: 2000, dstPort: 1000},
+	} {
+		pkt := ipv4Packet(byte(protoNumbers["tcp"]), tt.srcIP, tt.dstIP, tt.srcPort, tt.dstPort)
+		n, err := vm.Run(pkt)
+		if err != nil {
+			t.Fatalf("%s: vm.Run: %v", tt.name, err)
+		}
+		if n == 0 {
+			t.Errorf("%s: vm.Run rejected a packet that should match the bidirectional rule", tt.name)
+		}
+	}
+}
+
+// TestRuleBPFIPv6NetsError asserts that a side scoped to IPv6-only nets
+// fails BPF() rather than silently compiling a program that matches any
+// IPv4 traffic for that field.
+func TestRuleBPFIPv6NetsError(t *testing.T) {
+	r, err := ParseRule(`alert tcp 2001:db8::/32 any -> any any (sid:1; msg:"foo"; content:"A";)`)
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	if _, _, err := r.BPF(bpfVars); err == nil {
+		t.Errorf("BPF: got nil error for an IPv6-only net; want an error")
+	}
+}
+
+func TestRulesetBPFDedupes(t *testing.T) {
+	r1, err := ParseRule(`alert tcp $HOME_NET any -> any 80 (sid:1; msg:"a"; content:"A";)`)
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	r2, err := ParseRule(`alert tcp $HOME_NET any -> any 80 (sid:2; msg:"b"; content:"B";)`)
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	r3, err := ParseRule(`alert tcp $HOME_NET any -> any 443 (sid:3; msg:"c"; content:"C";)`)
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+
+	rs := Ruleset{r1, r2, r3}
+	expr, raw, err := rs.BPF(bpfVars)
+	if err != nil {
+		t.Fatalf("Ruleset.BPF: %v", err)
+	}
+	want := `(tcp and src net 10.0.0.0/8 and dst port 80) or (tcp and src net 10.0.0.0/8 and dst port 443)`
+	if expr != want {
+		t.Errorf("got expr %q; want %q", expr, want)
+	}
+	if len(raw) == 0 {
+		t.Errorf("got empty assembled program")
+	}
+}
@@ -0,0 +1,69 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import "strings"
+
+// MetadataEntry is one comma-separated entry of a metadata keyword, e.g.
+// "policy balanced-ips drop" becomes {Key: "policy", Value: "balanced-ips drop"}.
+type MetadataEntry struct {
+	Key   string
+	Value string
+}
+
+// parseMetadata splits a metadata keyword's value on top-level commas and
+// each resulting entry into a key and value on the first space. Suricata
+// permits repeated keys (e.g. multiple "policy" entries), so entries are
+// kept in a slice rather than a map, and original order is preserved.
+// Empty values are tolerated.
+func parseMetadata(value string) []MetadataEntry {
+	var entries []MetadataEntry
+	for _, e := range splitTopLevel(value, ',') {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		key, val := e, ""
+		if i := strings.IndexByte(e, ' '); i >= 0 {
+			key, val = e[:i], strings.TrimSpace(e[i+1:])
+		}
+		entries = append(entries, MetadataEntry{Key: key, Value: val})
+	}
+	return entries
+}
+
+// MetadataValues returns the values of every Metadata entry with the given
+// key, in the order they appeared in the rule.
+func (r *Rule) MetadataValues(key string) []string {
+	var vals []string
+	for _, e := range r.Metadata {
+		if e.Key == key {
+			vals = append(vals, e.Value)
+		}
+	}
+	return vals
+}
+
+// HasMetadata reports whether the Rule has a Metadata entry matching both
+// key and value.
+func (r *Rule) HasMetadata(key, value string) bool {
+	for _, e := range r.Metadata {
+		if e.Key == key && e.Value == value {
+			return true
+		}
+	}
+	return false
+}
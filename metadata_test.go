@@ -0,0 +1,73 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		value string
+		want  []MetadataEntry
+	}{
+		{
+			name:  "single",
+			value: "ruleset community",
+			want:  []MetadataEntry{{Key: "ruleset", Value: "community"}},
+		},
+		{
+			name:  "repeated key",
+			value: "policy balanced-ips drop, policy security-ips drop",
+			want: []MetadataEntry{
+				{Key: "policy", Value: "balanced-ips drop"},
+				{Key: "policy", Value: "security-ips drop"},
+			},
+		},
+		{
+			name:  "empty value tolerated",
+			value: "former_category",
+			want:  []MetadataEntry{{Key: "former_category", Value: ""}},
+		},
+	} {
+		if got := parseMetadata(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseMetadata(%q) = %+v; want %+v", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataHelpers(t *testing.T) {
+	r := &Rule{Metadata: []MetadataEntry{
+		{Key: "policy", Value: "balanced-ips drop"},
+		{Key: "policy", Value: "security-ips drop"},
+		{Key: "ruleset", Value: "community"},
+	}}
+
+	if got, want := r.MetadataValues("policy"), []string{"balanced-ips drop", "security-ips drop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MetadataValues(policy) = %v; want %v", got, want)
+	}
+	if got := r.MetadataValues("missing"); got != nil {
+		t.Errorf("MetadataValues(missing) = %v; want nil", got)
+	}
+	if !r.HasMetadata("ruleset", "community") {
+		t.Error("HasMetadata(ruleset, community) = false; want true")
+	}
+	if r.HasMetadata("ruleset", "nonexistent") {
+		t.Error("HasMetadata(ruleset, nonexistent) = true; want false")
+	}
+}
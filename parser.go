@@ -0,0 +1,317 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// boolContentOptions are the boolean content options (including the http_*
+// buffer options) that this package records verbatim on Content.Options,
+// rather than modeling their semantics.
+var boolContentOptions = map[string]bool{
+	"nocase":           true,
+	"rawbytes":         true,
+	"startswith":       true,
+	"endswith":         true,
+	"http_uri":         true,
+	"http_raw_uri":     true,
+	"http_header":      true,
+	"http_raw_header":  true,
+	"http_method":      true,
+	"http_cookie":      true,
+	"http_raw_cookie":  true,
+	"http_client_body": true,
+	"http_server_body": true,
+	"http_user_agent":  true,
+	"http_host":        true,
+	"http_raw_host":    true,
+	"http_stat_code":   true,
+	"http_stat_msg":    true,
+}
+
+// intContentOptions are content options that carry an integer value.
+var intContentOptions = map[string]bool{
+	"offset":   true,
+	"depth":    true,
+	"distance": true,
+	"within":   true,
+	"urilen":   true,
+	"isdataat": true,
+}
+
+// splitList turns a bracketed, comma separated rule header field (e.g.
+// "[80,443]") into its elements; a plain field (e.g. "$HOME_NET", "any") is
+// returned as a single element slice.
+func splitList(s string) []string {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		inner := s[1 : len(s)-1]
+		return strings.Split(inner, ",")
+	}
+	return []string{s}
+}
+
+// parseHeader parses the action/protocol/src/direction/dst portion of a rule
+// into r.
+func parseHeader(r *Rule, header string) error {
+	f := strings.Fields(header)
+	if len(f) != 7 {
+		return fmt.Errorf("invalid rule header: %q", header)
+	}
+	r.Action = f[0]
+	r.Protocol = f[1]
+	r.Source = Network{Nets: splitList(f[2]), Ports: splitList(f[3])}
+	switch f[4] {
+	case "->":
+	case "<>":
+		r.Bidirectional = true
+	default:
+		return fmt.Errorf("invalid rule direction: %q", f[4])
+	}
+	r.Destination = Network{Nets: splitList(f[5]), Ports: splitList(f[6])}
+	return nil
+}
+
+// splitTopLevel splits s on sep, ignoring occurrences of sep inside double
+// quoted strings (and skipping over backslash escapes within them).
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	var buf bytes.Buffer
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && inQuotes && i+1 < len(s):
+			buf.WriteByte(c)
+			buf.WriteByte(s[i+1])
+			i++
+		case c == '"':
+			inQuotes = !inQuotes
+			buf.WriteByte(c)
+		case c == sep && !inQuotes:
+			parts = append(parts, buf.String())
+			buf.Reset()
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if strings.TrimSpace(buf.String()) != "" {
+		parts = append(parts, buf.String())
+	}
+	return parts
+}
+
+// trimQuotes strips a single pair of surrounding double quotes from s, if
+// present.
+func trimQuotes(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parseContent decodes a content match body (the text between the
+// surrounding quotes) into its raw byte pattern, resolving backslash
+// escapes (e.g. \;) and |hex hex ...| escapes.
+func parseContent(s string) ([]byte, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '\\':
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("trailing backslash in content %q", s)
+			}
+			buf.WriteByte(s[i+1])
+			i += 2
+		case '|':
+			end := strings.IndexByte(s[i+1:], '|')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated hex escape in content %q", s)
+			}
+			hexPart := s[i+1 : i+1+end]
+			for _, tok := range strings.Fields(hexPart) {
+				if len(tok)%2 != 0 {
+					return nil, fmt.Errorf("invalid hex escape %q in content %q", tok, s)
+				}
+				b, err := hex.DecodeString(tok)
+				if err != nil {
+					return nil, fmt.Errorf("invalid hex escape %q in content %q: %v", tok, s, err)
+				}
+				buf.Write(b)
+			}
+			i = i + 1 + end + 1
+		default:
+			buf.WriteByte(s[i])
+			i++
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// parseContentValue parses a content option's value (e.g. `!"AA"`) into its
+// negation flag and decoded pattern.
+func parseContentValue(value string) (bool, []byte, error) {
+	v := value
+	var negate bool
+	if strings.HasPrefix(v, "!") {
+		negate = true
+		v = v[1:]
+	}
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return false, nil, fmt.Errorf("content value not quoted: %q", value)
+	}
+	p, err := parseContent(v[1 : len(v)-1])
+	if err != nil {
+		return false, nil, err
+	}
+	return negate, p, nil
+}
+
+// parseOptions parses the semicolon-delimited option body of a rule into r.
+func parseOptions(r *Rule, body string) error {
+	var cur *Content
+	pos := DataPos(pktData)
+	for _, raw := range splitTopLevel(body, ';') {
+		opt := strings.TrimSpace(raw)
+		if opt == "" {
+			continue
+		}
+		var key, value string
+		if idx := strings.IndexByte(opt, ':'); idx >= 0 {
+			key = strings.TrimSpace(opt[:idx])
+			value = strings.TrimSpace(opt[idx+1:])
+		} else {
+			key = opt
+		}
+
+		switch {
+		case key == "msg":
+			if value == "" {
+				return fmt.Errorf("msg requires a value")
+			}
+			r.Description = trimQuotes(value)
+		case key == "sid":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid sid %q: %v", value, err)
+			}
+			r.SID = v
+		case key == "rev":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid rev %q: %v", value, err)
+			}
+			r.Revision = v
+		case key == "content":
+			neg, pattern, err := parseContentValue(value)
+			if err != nil {
+				return err
+			}
+			cur = &Content{DataPosition: pos, Pattern: pattern, Negate: neg}
+			r.Contents = append(r.Contents, cur)
+		case key == "fast_pattern":
+			if cur == nil {
+				return fmt.Errorf("fast_pattern without a preceding content")
+			}
+			fp := FastPattern{Enabled: true}
+			switch {
+			case value == "only":
+				fp.Only = true
+			case value != "":
+				parts := strings.SplitN(value, ",", 2)
+				if len(parts) != 2 {
+					return fmt.Errorf("invalid fast_pattern value %q", value)
+				}
+				off, err := strconv.Atoi(parts[0])
+				if err != nil {
+					return fmt.Errorf("invalid fast_pattern offset %q: %v", parts[0], err)
+				}
+				length, err := strconv.Atoi(parts[1])
+				if err != nil {
+					return fmt.Errorf("invalid fast_pattern length %q: %v", parts[1], err)
+				}
+				fp.Offset, fp.Length = off, length
+			}
+			cur.FastPattern = fp
+		case key == "file_data":
+			pos = fileData
+			cur = nil
+		case key == "pkt_data":
+			pos = pktData
+			cur = nil
+		case key == "reference":
+			parts := strings.SplitN(value, ",", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid reference %q", value)
+			}
+			r.References = append(r.References, &Reference{Type: parts[0], Value: parts[1]})
+		case key == "pcre":
+			p, err := parsePCRE(value, len(r.Contents)-1)
+			if err != nil {
+				return err
+			}
+			r.PCREs = append(r.PCREs, p)
+		case key == "metadata":
+			r.Metadata = append(r.Metadata, parseMetadata(value)...)
+		case intContentOptions[key]:
+			if cur == nil {
+				return fmt.Errorf("%s without a preceding content", key)
+			}
+			v, err := strconv.Atoi(trimQuotes(value))
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", key, value, err)
+			}
+			cur.Options = append(cur.Options, &ContentOption{key, v})
+		case boolContentOptions[key]:
+			if cur == nil {
+				return fmt.Errorf("%s without a preceding content", key)
+			}
+			cur.Options = append(cur.Options, &ContentOption{key, 0})
+		default:
+			if r.Tags == nil {
+				r.Tags = map[string]string{}
+			}
+			r.Tags[key] = value
+		}
+	}
+	return nil
+}
+
+// ParseRule parses a single Suricata/Snort rule string into a Rule.
+func ParseRule(rule string) (*Rule, error) {
+	rule = strings.TrimSpace(rule)
+	open := strings.IndexByte(rule, '(')
+	if open < 0 {
+		return nil, fmt.Errorf("invalid rule, no options: %q", rule)
+	}
+	end := strings.LastIndexByte(rule, ')')
+	if end < 0 || end < open {
+		return nil, fmt.Errorf("invalid rule, unterminated options: %q", rule)
+	}
+
+	r := &Rule{}
+	if err := parseHeader(r, rule[:open]); err != nil {
+		return nil, err
+	}
+	if err := parseOptions(r, rule[open+1:end]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -112,239 +112,250 @@ func TestContentFormatPattern(t *testing.T) {
 	}
 }
 
-func TestParseRule(t *testing.T) {
-	for _, tt := range []struct {
-		name    string
-		rule    string
-		want    *Rule
-		wantErr bool
-	}{
-		{
-			name: "simple content",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"AA"; rev:2);`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1337,
-				Revision:    2,
-				Description: "foo",
-				Contents:    []*Content{&Content{Pattern: []byte{0x41, 0x41}}},
-			},
+var parseRuleTests = []struct {
+	name    string
+	rule    string
+	want    *Rule
+	wantErr bool
+}{
+	{
+		name: "simple content",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"AA"; rev:2);`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1337,
+			Revision:    2,
+			Description: "foo",
+			Contents:    []*Content{&Content{Pattern: []byte{0x41, 0x41}}},
 		},
-		{
-			name: "bidirectional",
-			rule: `alert udp $HOME_NET any <> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"AA"; rev:2);`,
-			want: &Rule{
-				Action:        "alert",
-				Protocol:      "udp",
-				Source:        Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination:   Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				Bidirectional: true,
-				SID:           1337,
-				Revision:      2,
-				Description:   "foo",
-				Contents:      []*Content{&Content{Pattern: []byte{0x41, 0x41}}},
-			},
+	},
+	{
+		name: "bidirectional",
+		rule: `alert udp $HOME_NET any <> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"AA"; rev:2);`,
+		want: &Rule{
+			Action:        "alert",
+			Protocol:      "udp",
+			Source:        Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination:   Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			Bidirectional: true,
+			SID:           1337,
+			Revision:      2,
+			Description:   "foo",
+			Contents:      []*Content{&Content{Pattern: []byte{0x41, 0x41}}},
 		},
-		{
-			name: "not content",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:!"AA");`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1337,
-				Description: "foo",
-				Contents:    []*Content{&Content{Pattern: []byte{0x41, 0x41}, Negate: true}},
-			},
+	},
+	{
+		name: "not content",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:!"AA");`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1337,
+			Description: "foo",
+			Contents:    []*Content{&Content{Pattern: []byte{0x41, 0x41}, Negate: true}},
 		},
-		{
-			name: "multiple contents",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"AA"; content:"BB");`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1337,
-				Description: "foo",
-				Contents: []*Content{&Content{Pattern: []byte{0x41, 0x41}},
-					&Content{Pattern: []byte{0x42, 0x42}}},
-			},
+	},
+	{
+		name: "multiple contents",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"AA"; content:"BB");`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1337,
+			Description: "foo",
+			Contents: []*Content{&Content{Pattern: []byte{0x41, 0x41}},
+				&Content{Pattern: []byte{0x42, 0x42}}},
 		},
-		{
-			name: "hex content",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"A|42 43|D|45|");`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1337,
-				Description: "foo",
-				Contents:    []*Content{&Content{Pattern: []byte{0x41, 0x42, 0x43, 0x44, 0x45}}},
-			},
+	},
+	{
+		name: "hex content",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"A|42 43|D|45|");`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1337,
+			Description: "foo",
+			Contents:    []*Content{&Content{Pattern: []byte{0x41, 0x42, 0x43, 0x44, 0x45}}},
 		},
-		{
-			name: "tags",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:!"AA"; classtype:foo);`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1337,
-				Description: "foo",
-				Contents:    []*Content{&Content{Pattern: []byte{0x41, 0x41}, Negate: true}},
-				Tags:        map[string]string{"classtype": "foo"},
-			},
+	},
+	{
+		name: "tags",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:!"AA"; classtype:foo);`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1337,
+			Description: "foo",
+			Contents:    []*Content{&Content{Pattern: []byte{0x41, 0x41}, Negate: true}},
+			Tags:        map[string]string{"classtype": "foo"},
 		},
-		{
-			name: "references",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"A"; reference:cve,2014; reference:url,www.suricata-ids.org);`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1337,
-				Description: "foo",
-				Contents:    []*Content{&Content{Pattern: []byte{0x41}}},
-				References:  []*Reference{&Reference{Type: "cve", Value: "2014"}, &Reference{Type: "url", Value: "www.suricata-ids.org"}},
-			},
+	},
+	{
+		name: "references",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:"A"; reference:cve,2014; reference:url,www.suricata-ids.org);`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1337,
+			Description: "foo",
+			Contents:    []*Content{&Content{Pattern: []byte{0x41}}},
+			References:  []*Reference{&Reference{Type: "cve", Value: "2014"}, &Reference{Type: "url", Value: "www.suricata-ids.org"}},
 		},
-		{
-			name: "content options",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:!"AA"; http_header; offset:3);`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1337,
-				Description: "foo",
-				Contents: []*Content{&Content{
-					Pattern: []byte{0x41, 0x41},
-					Negate:  true,
-					Options: []*ContentOption{&ContentOption{"http_header", 0}, &ContentOption{"offset", 3}},
-				}},
-			},
+	},
+	{
+		name: "content options",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1337; msg:"foo"; content:!"AA"; http_header; offset:3);`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1337,
+			Description: "foo",
+			Contents: []*Content{&Content{
+				Pattern: []byte{0x41, 0x41},
+				Negate:  true,
+				Options: []*ContentOption{&ContentOption{"http_header", 0}, &ContentOption{"offset", 3}},
+			}},
 		},
-		{
-			name: "multiple contents and options",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; msg:"a"; content:"A"; http_header; fast_pattern; content:"B"; http_uri);`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1,
-				Description: "a",
-				Contents: []*Content{
-					&Content{Pattern: []byte{0x41}, Options: []*ContentOption{&ContentOption{"http_header", 0}}, FastPattern: FastPattern{Enabled: true}},
-					&Content{Pattern: []byte{0x42}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
-				},
+	},
+	{
+		name: "multiple contents and options",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; msg:"a"; content:"A"; http_header; fast_pattern; content:"B"; http_uri);`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1,
+			Description: "a",
+			Contents: []*Content{
+				&Content{Pattern: []byte{0x41}, Options: []*ContentOption{&ContentOption{"http_header", 0}}, FastPattern: FastPattern{Enabled: true}},
+				&Content{Pattern: []byte{0x42}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
 			},
 		},
-		{
-			name: "multiple contents and multiple options",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; msg:"a"; content:"A"; http_header; fast_pattern:0,42; nocase; content:"B"; http_uri);`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1,
-				Description: "a",
-				Contents: []*Content{
-					&Content{Pattern: []byte{0x41}, Options: []*ContentOption{&ContentOption{"http_header", 0}, &ContentOption{"nocase", 0}}, FastPattern: FastPattern{Enabled: true, Offset: 0, Length: 42}},
-					&Content{Pattern: []byte{0x42}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
-				},
+	},
+	{
+		name: "multiple contents and multiple options",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; msg:"a"; content:"A"; http_header; fast_pattern:0,42; nocase; content:"B"; http_uri);`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1,
+			Description: "a",
+			Contents: []*Content{
+				&Content{Pattern: []byte{0x41}, Options: []*ContentOption{&ContentOption{"http_header", 0}, &ContentOption{"nocase", 0}}, FastPattern: FastPattern{Enabled: true, Offset: 0, Length: 42}},
+				&Content{Pattern: []byte{0x42}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
 			},
 		},
-		{
-			name: "multiple contents with file_data",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; msg:"a"; file_data; content:"A"; http_header; nocase; content:"B"; http_uri);`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1,
-				Description: "a",
-				Contents: []*Content{
-					&Content{DataPosition: 1, Pattern: []byte{0x41}, Options: []*ContentOption{&ContentOption{"http_header", 0}, &ContentOption{"nocase", 0}}},
-					&Content{DataPosition: 1, Pattern: []byte{0x42}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
-				},
+	},
+	{
+		name: "multiple contents with file_data",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; msg:"a"; file_data; content:"A"; http_header; nocase; content:"B"; http_uri);`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1,
+			Description: "a",
+			Contents: []*Content{
+				&Content{DataPosition: 1, Pattern: []byte{0x41}, Options: []*ContentOption{&ContentOption{"http_header", 0}, &ContentOption{"nocase", 0}}},
+				&Content{DataPosition: 1, Pattern: []byte{0x42}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
 			},
 		},
-		{
-			name: "multiple contents with file_data and pkt_data",
-			rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; msg:"a"; file_data; content:"A"; http_header; nocase; content:"B"; http_uri; pkt_data; content:"C"; http_uri;)`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "udp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
-				SID:         1,
-				Description: "a",
-				Contents: []*Content{
-					&Content{DataPosition: 1, Pattern: []byte{0x41}, Options: []*ContentOption{&ContentOption{"http_header", 0}, &ContentOption{"nocase", 0}}},
-					&Content{DataPosition: 1, Pattern: []byte{0x42}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
-					&Content{DataPosition: 0, Pattern: []byte{0x43}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
-				},
+	},
+	{
+		name: "multiple contents with file_data and pkt_data",
+		rule: `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; msg:"a"; file_data; content:"A"; http_header; nocase; content:"B"; http_uri; pkt_data; content:"C"; http_uri;)`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "udp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"any"}},
+			SID:         1,
+			Description: "a",
+			Contents: []*Content{
+				&Content{DataPosition: 1, Pattern: []byte{0x41}, Options: []*ContentOption{&ContentOption{"http_header", 0}, &ContentOption{"nocase", 0}}},
+				&Content{DataPosition: 1, Pattern: []byte{0x42}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
+				&Content{DataPosition: 0, Pattern: []byte{0x43}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}},
 			},
 		},
-		{
-			name: "Complex VRT rule",
-			rule: `alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"VRT BLACKLIST URI request for known malicious URI - /tongji.js"; flow:to_server,established; content:"/tongji.js"; fast_pattern:only; http_uri; content:"Host|3A| "; http_header; pcre:"/Host\x3a[^\r\n]*?\.tongji/Hi"; metadata:impact_flag red, policy balanced-ips drop, policy security-ips drop, ruleset community, service http; reference:url,labs.snort.org/docs/17904.html; classtype:trojan-activity; sid:17904; rev:6;)`,
-			want: &Rule{
-				Action:      "alert",
-				Protocol:    "tcp",
-				Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
-				Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"$HTTP_PORTS"}},
-				SID:         17904,
-				Revision:    6,
-				Description: "VRT BLACKLIST URI request for known malicious URI - /tongji.js",
-				References:  []*Reference{&Reference{Type: "url", Value: "labs.snort.org/docs/17904.html"}},
-				Contents: []*Content{
-					&Content{Pattern: []byte{0x2f, 0x74, 0x6f, 0x6e, 0x67, 0x6a, 0x69, 0x2e, 0x6a, 0x73}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}, FastPattern: FastPattern{Enabled: true, Only: true}},
-					&Content{Pattern: []byte{0x48, 0x6f, 0x73, 0x74, 0x3a, 0x20}, Options: []*ContentOption{&ContentOption{"http_header", 0}}},
-				},
-				Tags: map[string]string{"flow": "to_server,established", "classtype": "trojan-activity"},
+	},
+	{
+		name: "Complex VRT rule",
+		rule: `alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"VRT BLACKLIST URI request for known malicious URI - /tongji.js"; flow:to_server,established; content:"/tongji.js"; fast_pattern:only; http_uri; content:"Host|3A| "; http_header; pcre:"/Host\x3a[^\r\n]*?\.tongji/Hi"; metadata:impact_flag red, policy balanced-ips drop, policy security-ips drop, ruleset community, service http; reference:url,labs.snort.org/docs/17904.html; classtype:trojan-activity; sid:17904; rev:6;)`,
+		want: &Rule{
+			Action:      "alert",
+			Protocol:    "tcp",
+			Source:      Network{Nets: []string{"$HOME_NET"}, Ports: []string{"any"}},
+			Destination: Network{Nets: []string{"$EXTERNAL_NET"}, Ports: []string{"$HTTP_PORTS"}},
+			SID:         17904,
+			Revision:    6,
+			Description: "VRT BLACKLIST URI request for known malicious URI - /tongji.js",
+			References:  []*Reference{&Reference{Type: "url", Value: "labs.snort.org/docs/17904.html"}},
+			Contents: []*Content{
+				&Content{Pattern: []byte{0x2f, 0x74, 0x6f, 0x6e, 0x67, 0x6a, 0x69, 0x2e, 0x6a, 0x73}, Options: []*ContentOption{&ContentOption{"http_uri", 0}}, FastPattern: FastPattern{Enabled: true, Only: true}},
+				&Content{Pattern: []byte{0x48, 0x6f, 0x73, 0x74, 0x3a, 0x20}, Options: []*ContentOption{&ContentOption{"http_header", 0}}},
 			},
+			PCREs: []*PCRE{
+				&PCRE{Pattern: []byte(`Host\x3a[^\r\n]*?\.tongji`), DataPosition: httpHeaderData, CaseInsensitive: true, AfterContent: 1},
+			},
+			Metadata: []MetadataEntry{
+				{Key: "impact_flag", Value: "red"},
+				{Key: "policy", Value: "balanced-ips drop"},
+				{Key: "policy", Value: "security-ips drop"},
+				{Key: "ruleset", Value: "community"},
+				{Key: "service", Value: "http"},
+			},
+			Tags: map[string]string{"flow": "to_server,established", "classtype": "trojan-activity"},
 		},
-		// Errors
-		//TODO: Fix lexer with invalid direction. This test causes an infinite loop.
-		//{
-			//name:    "invalid direction",
-			//rule:    `alert udp $HOME_NET any *# $EXTERNAL_NET any (sid:2; msg:"foo"; content:"A");`,
-			//wantErr: true,
-		//},
-		{
-			name:    "invalid sid",
-			rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:"a");`,
-			wantErr: true,
-		},
-		{
-			name:    "invalid content option",
-			rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; content:"foo"; offset:"a");`,
-			wantErr: true,
-		},
-		{
-			name:    "invalid content value",
-			rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; content:!; offset:"a");`,
-			wantErr: true,
-		},
-		{
-			name:    "invalid msg",
-			rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:2; msg; content:"A");`,
-			wantErr: true,
-		},
-	} {
+	},
+	// Errors
+	{
+		name:    "invalid direction",
+		rule:    `alert udp $HOME_NET any *# $EXTERNAL_NET any (sid:2; msg:"foo"; content:"A");`,
+		wantErr: true,
+	},
+	{
+		name:    "invalid sid",
+		rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:"a");`,
+		wantErr: true,
+	},
+	{
+		name:    "invalid content option",
+		rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; content:"foo"; offset:"a");`,
+		wantErr: true,
+	},
+	{
+		name:    "invalid content value",
+		rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:1; content:!; offset:"a");`,
+		wantErr: true,
+	},
+	{
+		name:    "invalid msg",
+		rule:    `alert udp $HOME_NET any -> $EXTERNAL_NET any (sid:2; msg; content:"A");`,
+		wantErr: true,
+	},
+}
+
+func TestParseRule(t *testing.T) {
+	for _, tt := range parseRuleTests {
 		got, err := ParseRule(tt.rule)
 		if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.wantErr {
 			t.Fatal(spew.Sprintf("%s: got=%+v,%+v; want=%+v,%+v", tt.name, got, err, tt.want, tt.wantErr))
@@ -352,6 +363,21 @@ func TestParseRule(t *testing.T) {
 	}
 }
 
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range parseRuleTests {
+		if tt.wantErr {
+			continue
+		}
+		got, err := ParseRule(tt.want.String())
+		if err != nil {
+			t.Fatalf("%s: ParseRule(String()) failed: %v\nstring: %s", tt.name, err, tt.want.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatal(spew.Sprintf("%s: round trip mismatch: got=%+v; want=%+v\nstring: %s", tt.name, got, tt.want, tt.want.String()))
+		}
+	}
+}
+
 func TestRE(t *testing.T) {
 	for _, tt := range []struct {
 		rule string
@@ -0,0 +1,180 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PCRE describes a pcre keyword: a Perl-compatible regular expression
+// matched against a buffer, with the same negation semantics as Content.
+// Its buffer is selected only by its modifier letters; the file_data and
+// pkt_data keywords do not change it.
+type PCRE struct {
+	Pattern []byte
+	Negate  bool
+
+	// DataPosition is the buffer this PCRE matches against, set by a buffer
+	// modifier letter (U, I, P, H, D, M, C, S, Y, V, W); it defaults to
+	// pktData, the same as an unmodified Content.
+	DataPosition DataPos
+
+	// AfterContent is the index in Rule.Contents of the Content this PCRE
+	// immediately followed in the original rule text, or -1 if it preceded
+	// every Content. It lets RE() splice PCRE patterns back into their
+	// original sticky order.
+	AfterContent int
+
+	// Relative honors the R modifier: the match is anchored to the end of
+	// the previous content match rather than searched for anywhere in the
+	// buffer.
+	Relative bool
+
+	// CaseInsensitive, DotAll and MultiLine mirror the i, s and m
+	// modifiers and become inline (?ims) flags in ToRegexp.
+	CaseInsensitive bool
+	DotAll          bool
+	MultiLine       bool
+
+	// Extended, Anchored, NoDollarNewline and InvertGreediness mirror the
+	// x, A, E and G modifiers. They are recorded for round-tripping via
+	// String but are not reflected in ToRegexp.
+	Extended         bool
+	Anchored         bool
+	NoDollarNewline  bool
+	InvertGreediness bool
+}
+
+// ToRegexp renders a PCRE's pattern as a regular expression fragment,
+// translating the i/s/m modifiers into an inline (?ims) flag group.
+func (p *PCRE) ToRegexp() string {
+	var flags string
+	if p.CaseInsensitive {
+		flags += "i"
+	}
+	if p.DotAll {
+		flags += "s"
+	}
+	if p.MultiLine {
+		flags += "m"
+	}
+	if flags == "" {
+		return string(p.Pattern)
+	}
+	return fmt.Sprintf("(?%s)%s", flags, p.Pattern)
+}
+
+// unescapePCREBody resolves the rule-syntax escapes (\;, \/, \") that can
+// appear in a pcre pattern body; every other backslash sequence is left
+// untouched, since it belongs to the regular expression itself (e.g.
+// \x3a, \r, \n).
+func unescapePCREBody(s string) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ';', '/', '"':
+				buf.WriteByte(s[i+1])
+				i++
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.Bytes()
+}
+
+// parsePCRE parses a pcre option's value (the text after "pcre:"), e.g.
+// `"/Host\x3a[^\r\n]*?\.tongji/Hi"`, into a PCRE. afterContent is recorded
+// verbatim as PCRE.AfterContent.
+func parsePCRE(value string, afterContent int) (*PCRE, error) {
+	v := value
+	negate := false
+	if strings.HasPrefix(v, "!") {
+		negate = true
+		v = v[1:]
+	}
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return nil, fmt.Errorf("pcre value not quoted: %q", value)
+	}
+	body := v[1 : len(v)-1]
+	if len(body) < 2 {
+		return nil, fmt.Errorf("invalid pcre pattern %q", value)
+	}
+	delim := body[0]
+	end := strings.LastIndexByte(body[1:], delim)
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated pcre pattern %q", value)
+	}
+	end++ // end was relative to body[1:]
+
+	p := &PCRE{
+		Negate:       negate,
+		Pattern:      unescapePCREBody(body[1:end]),
+		AfterContent: afterContent,
+	}
+	for _, m := range body[end+1:] {
+		switch m {
+		case 'i':
+			p.CaseInsensitive = true
+		case 's':
+			p.DotAll = true
+		case 'm':
+			p.MultiLine = true
+		case 'x':
+			p.Extended = true
+		case 'A':
+			p.Anchored = true
+		case 'E':
+			p.NoDollarNewline = true
+		case 'G':
+			p.InvertGreediness = true
+		case 'R':
+			p.Relative = true
+		case 'O':
+			// Overrides the rule's fast_pattern configuration; not modeled.
+		case 'B':
+			p.DataPosition = pktData
+		case 'U':
+			p.DataPosition = httpURIData
+		case 'I':
+			p.DataPosition = httpRawURIData
+		case 'P':
+			p.DataPosition = httpClientBodyData
+		case 'H':
+			p.DataPosition = httpHeaderData
+		case 'D':
+			p.DataPosition = httpRawHeaderData
+		case 'M':
+			p.DataPosition = httpMethodData
+		case 'C':
+			p.DataPosition = httpCookieData
+		case 'S':
+			p.DataPosition = httpStatCodeData
+		case 'Y':
+			p.DataPosition = httpStatMsgData
+		case 'V':
+			p.DataPosition = httpUserAgentData
+		case 'W':
+			p.DataPosition = httpHostData
+		default:
+			return nil, fmt.Errorf("unknown pcre modifier %q in %q", m, value)
+		}
+	}
+	return p, nil
+}
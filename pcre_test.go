@@ -0,0 +1,107 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePCRE(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		value   string
+		want    *PCRE
+		wantErr bool
+	}{
+		{
+			name:  "simple",
+			value: `"/foo/"`,
+			want:  &PCRE{Pattern: []byte("foo")},
+		},
+		{
+			name:  "case insensitive and relative",
+			value: `"/foo/iR"`,
+			want:  &PCRE{Pattern: []byte("foo"), CaseInsensitive: true, Relative: true},
+		},
+		{
+			name:  "negated with buffer modifier",
+			value: `!"/foo/U"`,
+			want:  &PCRE{Pattern: []byte("foo"), Negate: true, DataPosition: httpURIData},
+		},
+		{
+			name:  "escaped semicolon and quote",
+			value: `"/a\;b\"c/"`,
+			want:  &PCRE{Pattern: []byte(`a;b"c`)},
+		},
+		{
+			name:    "unknown modifier",
+			value:   `"/foo/Z"`,
+			wantErr: true,
+		},
+		{
+			name:    "unquoted",
+			value:   `/foo/`,
+			wantErr: true,
+		},
+	} {
+		got, err := parsePCRE(tt.value, -1)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: parsePCRE(%q) error = %v, wantErr %v", tt.name, tt.value, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		tt.want.AfterContent = -1
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parsePCRE(%q) = %+v; want %+v", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPCREToRegexp(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input *PCRE
+		want  string
+	}{
+		{
+			name:  "no flags",
+			input: &PCRE{Pattern: []byte("foo")},
+			want:  "foo",
+		},
+		{
+			name:  "case insensitive and multiline",
+			input: &PCRE{Pattern: []byte("foo"), CaseInsensitive: true, MultiLine: true},
+			want:  "(?im)foo",
+		},
+	} {
+		if got := tt.input.ToRegexp(); got != tt.want {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRESplicesPCRE(t *testing.T) {
+	r, err := ParseRule(`alert tcp any any -> any any (sid:1; msg:"foo"; content:"AA"; pcre:"/bb/R"; content:"CC";)`)
+	if err != nil {
+		t.Fatalf("ParseRule: %v", err)
+	}
+	want := `.*AA` + `bb` + `.*CC`
+	if got := r.RE(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,224 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gonids implements a parser for the rule syntax used by Suricata and Snort.
+package gonids
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DataPos identifies the sticky buffer that a Content or PCRE item is matched
+// against, as selected by keywords like file_data and pkt_data.
+type DataPos int
+
+// Supported DataPos values. pktData and fileData are selected by the
+// file_data/pkt_data keywords for Content; the http* values are selected by
+// the corresponding PCRE buffer modifier (U, I, P, H, D, M, C, S, Y, V, W).
+const (
+	pktData DataPos = iota
+	fileData
+	httpURIData
+	httpRawURIData
+	httpClientBodyData
+	httpHeaderData
+	httpRawHeaderData
+	httpMethodData
+	httpCookieData
+	httpStatCodeData
+	httpStatMsgData
+	httpUserAgentData
+	httpHostData
+)
+
+// Network describes the IP addresses and ports on one side of a Rule header.
+type Network struct {
+	// Nets is a list of IPs, CIDRs or variables (e.g. $HOME_NET).
+	Nets []string
+	// Ports is a list of ports, port ranges or variables (e.g. $HTTP_PORTS).
+	Ports []string
+}
+
+// Reference describes an external reference attached to a Rule via the
+// reference keyword (e.g. reference:cve,2014-1234;).
+type Reference struct {
+	Type  string
+	Value string
+}
+
+// ContentOption describes a modifier that applies to the preceding Content,
+// such as nocase, http_uri or a value-carrying option like offset or depth.
+// Value is unused (0) for boolean options.
+type ContentOption struct {
+	Name  string
+	Value int
+}
+
+// FastPattern models the fast_pattern content option, used to tell the
+// multi-pattern matcher which content to key its search on.
+type FastPattern struct {
+	Enabled bool
+	// Only is set for fast_pattern:only.
+	Only bool
+	// Offset and Length are set for fast_pattern:offset,length.
+	Offset int
+	Length int
+}
+
+// Content describes a content match keyword and the options that modify it.
+type Content struct {
+	// DataPosition records which sticky buffer (set by file_data, pkt_data,
+	// etc.) this content applies to.
+	DataPosition DataPos
+	Pattern      []byte
+	Negate       bool
+	Options      []*ContentOption
+	FastPattern  FastPattern
+}
+
+// Rule describes a single parsed Suricata/Snort rule.
+type Rule struct {
+	Action        string
+	Protocol      string
+	Source        Network
+	Destination   Network
+	Bidirectional bool
+	SID           int
+	Revision      int
+	Description   string
+	References    []*Reference
+	// Tags holds keywords this package does not otherwise model explicitly
+	// (e.g. classtype, flow), keyed by keyword name.
+	Tags     map[string]string
+	Contents []*Content
+	PCREs    []*PCRE
+	Metadata []MetadataEntry
+}
+
+// isRegexMeta reports whether b must be backslash-escaped to appear
+// literally in a regular expression.
+func isRegexMeta(b byte) bool {
+	switch b {
+	case '.', '+', '*', '?', '(', ')', '|', '[', ']', '{', '}', '^', '$', '\\':
+		return true
+	}
+	return false
+}
+
+// ToRegexp renders a Content's Pattern as a regular expression fragment.
+// Bytes that are regexp metacharacters are backslash-escaped; raw CR and LF
+// bytes are each rendered as an escaped dot (`\.`), which matches a literal
+// '.' rather than the original byte.
+func (c *Content) ToRegexp() string {
+	var buf bytes.Buffer
+	for _, b := range c.Pattern {
+		switch b {
+		case '\r', '\n':
+			buf.WriteString(`\.`)
+		default:
+			if isRegexMeta(b) {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(b)
+		}
+	}
+	return buf.String()
+}
+
+// needsHexEscape reports whether b must be rendered as a |XX| hex escape in
+// Suricata content syntax, either because it has special meaning in that
+// syntax (", ;, :, |, \) or because it falls outside printable ASCII.
+func needsHexEscape(b byte) bool {
+	switch b {
+	case '"', ';', ':', '|', '\\':
+		return true
+	}
+	return b < 0x20 || b > 0x7e
+}
+
+// FormatPattern renders a Content's Pattern back into Suricata content
+// syntax, hex-escaping runs of bytes that cannot appear literally.
+func (c *Content) FormatPattern() string {
+	var buf bytes.Buffer
+	p := c.Pattern
+	for i := 0; i < len(p); {
+		if !needsHexEscape(p[i]) {
+			buf.WriteByte(p[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(p) && needsHexEscape(p[j]) {
+			j++
+		}
+		buf.WriteByte('|')
+		for k := i; k < j; k++ {
+			if k > i {
+				buf.WriteByte(' ')
+			}
+			fmt.Fprintf(&buf, "%02X", p[k])
+		}
+		buf.WriteByte('|')
+		i = j
+	}
+	return buf.String()
+}
+
+// gapRE returns the regular expression fragment that should separate the
+// previous Content from c, based on whatever proximity option c carries.
+func gapRE(c *Content) string {
+	for _, o := range c.Options {
+		if o.Name == "within" {
+			return fmt.Sprintf(".{0,%d}", o.Value)
+		}
+	}
+	return ".*"
+}
+
+// RE returns a regular expression approximating the order and spacing of the
+// Rule's Content and PCRE keywords. A match does not guarantee the full rule
+// matches (buffer selection and distance/depth semantics are not fully
+// modeled). Nor does a failed match rule one out: negated Content and PCRE
+// items are rendered as required matches like the others, and CR/LF bytes
+// in a Content become `\.` (see Content.ToRegexp).
+func (r *Rule) RE() string {
+	byContent := map[int][]*PCRE{}
+	for _, p := range r.PCREs {
+		byContent[p.AfterContent] = append(byContent[p.AfterContent], p)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(".*")
+	emitPCRE := func(p *PCRE) {
+		if !p.Relative {
+			buf.WriteString(".*")
+		}
+		buf.WriteString(p.ToRegexp())
+	}
+	for _, p := range byContent[-1] {
+		emitPCRE(p)
+	}
+	for i, c := range r.Contents {
+		if i > 0 {
+			buf.WriteString(gapRE(c))
+		}
+		buf.WriteString(c.ToRegexp())
+		for _, p := range byContent[i] {
+			emitPCRE(p)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,251 @@
+/* Copyright 2016 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gonids
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// String renders a Network (one side of a rule header) back into Suricata
+// syntax, e.g. "$HOME_NET any" or "$EXTERNAL_NET [80,443]".
+func (n Network) String() string {
+	return fmt.Sprintf("%s %s", formatList(n.Nets), formatList(n.Ports))
+}
+
+func formatList(l []string) string {
+	if len(l) == 1 {
+		return l[0]
+	}
+	return "[" + strings.Join(l, ",") + "]"
+}
+
+// String renders a Reference back into its "reference:type,value;" keyword.
+func (ref *Reference) String() string {
+	return fmt.Sprintf("reference:%s,%s;", ref.Type, ref.Value)
+}
+
+// String renders a Content back into its "content:..." keyword together
+// with the options and fast_pattern setting that follow it.
+func (c *Content) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("content:")
+	if c.Negate {
+		buf.WriteByte('!')
+	}
+	buf.WriteByte('"')
+	buf.WriteString(c.FormatPattern())
+	buf.WriteString(`";`)
+	for _, o := range c.Options {
+		if intContentOptions[o.Name] {
+			fmt.Fprintf(&buf, " %s:%d;", o.Name, o.Value)
+		} else {
+			fmt.Fprintf(&buf, " %s;", o.Name)
+		}
+	}
+	switch fp := c.FastPattern; {
+	case !fp.Enabled:
+	case fp.Only:
+		buf.WriteString(" fast_pattern:only;")
+	case fp.Offset != 0 || fp.Length != 0:
+		fmt.Fprintf(&buf, " fast_pattern:%d,%d;", fp.Offset, fp.Length)
+	default:
+		buf.WriteString(" fast_pattern;")
+	}
+	return buf.String()
+}
+
+// bufferModifier returns the single-letter pcre buffer modifier for a
+// DataPos, or 0 if it doesn't carry one (pktData, the default, has none).
+func bufferModifier(pos DataPos) byte {
+	switch pos {
+	case httpURIData:
+		return 'U'
+	case httpRawURIData:
+		return 'I'
+	case httpClientBodyData:
+		return 'P'
+	case httpHeaderData:
+		return 'H'
+	case httpRawHeaderData:
+		return 'D'
+	case httpMethodData:
+		return 'M'
+	case httpCookieData:
+		return 'C'
+	case httpStatCodeData:
+		return 'S'
+	case httpStatMsgData:
+		return 'Y'
+	case httpUserAgentData:
+		return 'V'
+	case httpHostData:
+		return 'W'
+	}
+	return 0
+}
+
+// String renders a PCRE back into its "pcre:"/pattern/flags";" keyword.
+// Modifier order is not preserved; ParseRule(p.String()) reproduces an
+// equivalent PCRE, not necessarily the original byte-for-byte text.
+func (p *PCRE) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("pcre:")
+	if p.Negate {
+		buf.WriteByte('!')
+	}
+	buf.WriteString(`"/`)
+	buf.WriteString(escapePCREBody(string(p.Pattern)))
+	buf.WriteByte('/')
+	if p.CaseInsensitive {
+		buf.WriteByte('i')
+	}
+	if p.DotAll {
+		buf.WriteByte('s')
+	}
+	if p.MultiLine {
+		buf.WriteByte('m')
+	}
+	if p.Extended {
+		buf.WriteByte('x')
+	}
+	if p.Anchored {
+		buf.WriteByte('A')
+	}
+	if p.NoDollarNewline {
+		buf.WriteByte('E')
+	}
+	if p.InvertGreediness {
+		buf.WriteByte('G')
+	}
+	if p.Relative {
+		buf.WriteByte('R')
+	}
+	if m := bufferModifier(p.DataPosition); m != 0 {
+		buf.WriteByte(m)
+	}
+	buf.WriteString(`";`)
+	return buf.String()
+}
+
+// escapePCREBody is the inverse of unescapePCREBody: it re-escapes the
+// characters that would otherwise end the pattern early (the delimiter,
+// the closing quote, or the option separator).
+func escapePCREBody(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ';', '"', '/':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// String renders a Rule back into canonical Suricata syntax: the header,
+// followed by options in a deterministic order (msg, flow, sticky-buffer
+// switches interleaved with Content/PCRE, metadata, reference, classtype,
+// sid, rev).
+func (r *Rule) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s", r.Action, r.Protocol, r.Source.String())
+	if r.Bidirectional {
+		buf.WriteString(" <> ")
+	} else {
+		buf.WriteString(" -> ")
+	}
+	buf.WriteString(r.Destination.String())
+	buf.WriteString(" (")
+
+	var opts []string
+	if r.Description != "" {
+		opts = append(opts, fmt.Sprintf(`msg:"%s";`, r.Description))
+	}
+	if v, ok := r.Tags["flow"]; ok {
+		opts = append(opts, fmt.Sprintf("flow:%s;", v))
+	}
+
+	pcresByContent := map[int][]*PCRE{}
+	for _, p := range r.PCREs {
+		pcresByContent[p.AfterContent] = append(pcresByContent[p.AfterContent], p)
+	}
+	for _, p := range pcresByContent[-1] {
+		opts = append(opts, p.String())
+	}
+
+	pos := DataPos(pktData)
+	for i, c := range r.Contents {
+		if c.DataPosition != pos {
+			if c.DataPosition == fileData {
+				opts = append(opts, "file_data;")
+			} else {
+				opts = append(opts, "pkt_data;")
+			}
+			pos = c.DataPosition
+		}
+		opts = append(opts, c.String())
+		for _, p := range pcresByContent[i] {
+			opts = append(opts, p.String())
+		}
+	}
+
+	if len(r.Metadata) > 0 {
+		parts := make([]string, len(r.Metadata))
+		for i, e := range r.Metadata {
+			if e.Value == "" {
+				parts[i] = e.Key
+			} else {
+				parts[i] = e.Key + " " + e.Value
+			}
+		}
+		opts = append(opts, fmt.Sprintf("metadata:%s;", strings.Join(parts, ", ")))
+	}
+
+	for _, ref := range r.References {
+		opts = append(opts, ref.String())
+	}
+
+	if v, ok := r.Tags["classtype"]; ok {
+		opts = append(opts, fmt.Sprintf("classtype:%s;", v))
+	}
+	var otherTags []string
+	for k := range r.Tags {
+		if k == "flow" || k == "classtype" {
+			continue
+		}
+		otherTags = append(otherTags, k)
+	}
+	sort.Strings(otherTags)
+	for _, k := range otherTags {
+		if v := r.Tags[k]; v != "" {
+			opts = append(opts, fmt.Sprintf("%s:%s;", k, v))
+		} else {
+			opts = append(opts, fmt.Sprintf("%s;", k))
+		}
+	}
+
+	opts = append(opts, fmt.Sprintf("sid:%d;", r.SID))
+	if r.Revision != 0 {
+		opts = append(opts, fmt.Sprintf("rev:%d;", r.Revision))
+	}
+
+	buf.WriteString(strings.Join(opts, " "))
+	buf.WriteString(")")
+	return buf.String()
+}